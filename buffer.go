@@ -6,109 +6,403 @@ package measuringringbuffer
 import (
 	"fmt"
 	"io"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Buffer is a ring buffer that tracks stats about how much time is spent on reading vs writing.
+//
+// The counters read by Stats (totalBytesRead, timeSpentReading, timeSpentWriting, totalTimeSpent,
+// timeSpentBlockedOnRateLimit, ready, lastReadStarted, lastWriteStarted) are atomics: ReadFrom and
+// WriteTo update most of them while holding mtx (needed for the ring-buffer arithmetic around
+// them), and set lastReadStarted/lastWriteStarted around the Read/Write call with mtx released.
+// Stats can snapshot them without taking mtx at all, so polling Stats doesn't contend with the hot
+// Read/Write path.
 type Buffer struct {
-	buf              []byte
-	mtx              sync.Mutex
-	cond             *sync.Cond
-	readIndex        int
-	ready            int
-	totalBytesRead   int64
-	readError        error
-	writeError       error
-	timeStarted      time.Time
-	lastReadStarted  time.Time
-	lastWriteStarted time.Time
-	timeSpentReading time.Duration
-	timeSpentWriting time.Duration
-	totalTimeSpent   time.Duration
+	buf                         []byte
+	capacity                    int // len(buf) at construction time; buf itself is nilled out by Close
+	mtx                         sync.Mutex
+	cond                        *sync.Cond
+	readIndex                   int
+	ready                       atomic.Int64
+	totalBytesRead              atomic.Int64
+	readError                   error
+	writeError                  error
+	timeStarted                 atomic.Int64 // unix nanos; 0 means unset
+	lastReadStarted             atomic.Int64 // unix nanos; 0 means unset
+	lastWriteStarted            atomic.Int64 // unix nanos; 0 means unset
+	timeSpentReading            atomic.Int64 // nanoseconds
+	timeSpentWriting            atomic.Int64 // nanoseconds
+	timeSpentBlockedOnRateLimit atomic.Int64 // nanoseconds
+	totalTimeSpent              atomic.Int64 // nanoseconds
+	readLimiter                 *rateLimiter
+	writeLimiter                *rateLimiter
+	readLatency                 latencyHistogram
+	writeLatency                latencyHistogram
+	writers                     []*writerSlot
+	hasWriters                  atomic.Bool
+	doneCount                   int
+	expectedDone                int
+	released                    bool
 }
 
 var _ io.WriterTo = &Buffer{}
 var _ io.ReaderFrom = &Buffer{}
+var _ io.Closer = &Buffer{}
+
+// Option configures optional behavior of a Buffer at construction time.
+type Option func(*Buffer)
+
+// WithReadRateLimit caps the read side of the Buffer to bytesPerSec bytes per second.
+func WithReadRateLimit(bytesPerSec int64) Option {
+	return func(b *Buffer) {
+		b.readLimiter = newRateLimiter(bytesPerSec)
+	}
+}
+
+// WithWriteRateLimit caps the write side of the Buffer to bytesPerSec bytes per second.
+func WithWriteRateLimit(bytesPerSec int64) Option {
+	return func(b *Buffer) {
+		b.writeLimiter = newRateLimiter(bytesPerSec)
+	}
+}
 
-func New(size int) *Buffer {
+func New(size int, opts ...Option) *Buffer {
+	buf := getPooledBuffer(size)
 	b := &Buffer{
-		buf: make([]byte, size),
+		buf:          buf,
+		capacity:     len(buf),
+		expectedDone: 2,
 	}
 	b.cond = sync.NewCond(&b.mtx)
+	for _, o := range opts {
+		o(b)
+	}
 	return b
 }
 
+// Close waits for the producer and every consumer (ReadFrom and WriteTo, or ReadFrom and all of
+// CopyMulti's writers) to have finished, then returns the underlying buffer to the pool. The
+// Buffer must not be used after Close returns.
+func (b *Buffer) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for b.doneCount < b.expectedDone {
+		b.cond.Wait()
+	}
+	if !b.released {
+		putPooledBuffer(b.buf)
+		b.buf = nil
+		b.released = true
+	}
+	return nil
+}
+
+// markDone records that one of the producer/consumer goroutines has finished. b.mtx must be held
+// by the caller.
+func (b *Buffer) markDone() {
+	b.doneCount++
+	if b.doneCount >= b.expectedDone {
+		b.cond.Broadcast()
+	}
+}
+
+// SetReadRateLimit changes the read side rate limit. A bytesPerSec of 0 disables rate limiting.
+func (b *Buffer) SetReadRateLimit(bytesPerSec int64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.readLimiter == nil {
+		b.readLimiter = newRateLimiter(bytesPerSec)
+		return
+	}
+	b.readLimiter.setRate(bytesPerSec)
+}
+
+// SetWriteRateLimit changes the write side rate limit. A bytesPerSec of 0 disables rate limiting.
+func (b *Buffer) SetWriteRateLimit(bytesPerSec int64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.writeLimiter == nil {
+		b.writeLimiter = newRateLimiter(bytesPerSec)
+		return
+	}
+	b.writeLimiter.setRate(bytesPerSec)
+}
+
 // Copy data from the reader to the writer.
 func (b *Buffer) Copy(w io.Writer, r io.Reader) (int64, error) {
 	go b.ReadFrom(r)
 	return b.WriteTo(w)
 }
 
-// Stats are statistics of an active Buffer.
-type Stats struct {
-	BufferCapacity   int
+// writerSlot is one sink's independent lag behind the producer when fanning out via CopyMulti. Its
+// read position is always b.readIndex-ready, same as the single-writer case in WriteTo.
+type writerSlot struct {
+	w                io.Writer
+	ready            int
+	bytesWritten     int64
+	timeSpentWriting time.Duration
+	lastWriteStarted time.Time
+	err              error
+	done             bool
+}
+
+// CopyMulti fans the reader out to multiple writers. Each writer gets its own cursor into the ring
+// buffer, so a slow writer only blocks the producer once it falls behind by len(buf) bytes; faster
+// writers are not held back by slower ones until the buffer fills. It returns once the reader and
+// every writer has finished. A read error is returned if there was one; otherwise the error of the
+// first failed writer (in the order of ws), if any.
+func (b *Buffer) CopyMulti(r io.Reader, ws ...io.Writer) (int64, error) {
+	b.writers = make([]*writerSlot, len(ws))
+	for i, w := range ws {
+		b.writers[i] = &writerSlot{w: w}
+	}
+	b.hasWriters.Store(true)
+	b.expectedDone = len(ws) + 1
+	var wg sync.WaitGroup
+	wg.Add(len(ws))
+	for _, slot := range b.writers {
+		slot := slot
+		go func() {
+			defer wg.Done()
+			b.writeToSlot(slot)
+		}()
+	}
+	sum, err := b.ReadFrom(r)
+	wg.Wait()
+	if err == nil {
+		for _, slot := range b.writers {
+			if slot.err != nil {
+				err = slot.err
+				break
+			}
+		}
+	}
+	return sum, err
+}
+
+func (b *Buffer) writeToSlot(slot *writerSlot) (int64, error) {
+	maxWrite := len(b.buf) / 8
+	if maxWrite < 1 {
+		maxWrite = 1
+	}
+	var sum int64
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	defer b.markDone()
+	defer func() {
+		slot.done = true
+		b.cond.Broadcast()
+	}()
+	for {
+		for slot.ready == 0 {
+			if b.readError != nil {
+				if b.readError == io.EOF {
+					return sum, nil
+				}
+				slot.err = fmt.Errorf("read error: %w", b.readError)
+				return sum, slot.err
+			}
+			b.cond.Wait()
+		}
+		var buf []byte
+		if b.readIndex-slot.ready >= 0 {
+			buf = b.buf[b.readIndex-slot.ready : b.readIndex]
+		} else {
+			buf = b.buf[b.readIndex-slot.ready+len(b.buf):]
+		}
+		slot.lastWriteStarted = time.Now()
+		b.mtx.Unlock()
+
+		if len(buf) > maxWrite {
+			buf = buf[:maxWrite]
+		}
+		n, err := slot.w.Write(buf)
+		dur := time.Since(slot.lastWriteStarted)
+		sum += int64(n)
+
+		b.mtx.Lock()
+		slot.ready -= n
+		slot.bytesWritten = sum
+		slot.lastWriteStarted = time.Time{}
+		slot.timeSpentWriting += dur
+		b.cond.Broadcast()
+		if err != nil {
+			slot.err = fmt.Errorf("write error: %w", err)
+			return sum, slot.err
+		}
+	}
+}
+
+// Latency holds percentile statistics for individual Read or Write call durations.
+type Latency struct {
+	P50 time.Duration
+	P90 time.Duration
+	P98 time.Duration
+	Max time.Duration
+}
+
+// WriterStats reports one CopyMulti sink's fan-out progress.
+type WriterStats struct {
 	BufferedBytes    int
-	BytesRead        int64
-	TotalTime        time.Duration
-	TimeSpentReading time.Duration
+	BytesWritten     int64
 	TimeSpentWriting time.Duration
 }
 
+// Stats are statistics of an active Buffer.
+type Stats struct {
+	BufferCapacity              int
+	BufferedBytes               int
+	BytesRead                   int64
+	TotalTime                   time.Duration
+	TimeSpentReading            time.Duration
+	TimeSpentWriting            time.Duration
+	TimeSpentBlockedOnRateLimit time.Duration
+	ReadLatency                 Latency
+	WriteLatency                Latency
+	PerWriter                   []WriterStats
+}
+
+// Stats reads the atomic counters directly, without taking b.mtx, so polling it doesn't contend
+// with the hot Read/Write path. Only the CopyMulti per-writer breakdown still takes b.mtx, since
+// writerSlot's fields aren't atomic.
 func (b *Buffer) Stats() Stats {
-	b.mtx.Lock()
-	defer b.mtx.Unlock()
 	now := time.Now()
 	s := Stats{
-		BufferCapacity:   len(b.buf),
-		BufferedBytes:    b.ready,
-		TimeSpentReading: b.timeSpentReading,
-		TimeSpentWriting: b.timeSpentWriting,
-		TotalTime:        b.totalTimeSpent,
-		BytesRead:        b.totalBytesRead,
+		BufferCapacity:              b.capacity,
+		BufferedBytes:               int(b.ready.Load()),
+		TimeSpentReading:            time.Duration(b.timeSpentReading.Load()),
+		TimeSpentWriting:            time.Duration(b.timeSpentWriting.Load()),
+		TimeSpentBlockedOnRateLimit: time.Duration(b.timeSpentBlockedOnRateLimit.Load()),
+		TotalTime:                   time.Duration(b.totalTimeSpent.Load()),
+		BytesRead:                   b.totalBytesRead.Load(),
+		ReadLatency:                 b.readLatency.snapshot(),
+		WriteLatency:                b.writeLatency.snapshot(),
 	}
-	if !b.timeStarted.IsZero() {
-		s.TotalTime += now.Sub(b.timeStarted)
+	if started := b.timeStarted.Load(); started != 0 {
+		s.TotalTime += now.Sub(time.Unix(0, started))
 	}
-	if !b.lastReadStarted.IsZero() {
-		s.TimeSpentReading += now.Sub(b.lastReadStarted)
+	if lastRead := b.lastReadStarted.Load(); lastRead != 0 {
+		s.TimeSpentReading += now.Sub(time.Unix(0, lastRead))
 	}
-	if !b.lastWriteStarted.IsZero() {
-		s.TimeSpentWriting += now.Sub(b.lastWriteStarted)
+	if lastWrite := b.lastWriteStarted.Load(); lastWrite != 0 {
+		s.TimeSpentWriting += now.Sub(time.Unix(0, lastWrite))
+	}
+	if b.hasWriters.Load() {
+		b.mtx.Lock()
+		s.BufferedBytes = b.maxReady()
+		s.PerWriter = make([]WriterStats, len(b.writers))
+		for i, slot := range b.writers {
+			ws := WriterStats{
+				BufferedBytes:    slot.ready,
+				BytesWritten:     slot.bytesWritten,
+				TimeSpentWriting: slot.timeSpentWriting,
+			}
+			if !slot.lastWriteStarted.IsZero() {
+				ws.TimeSpentWriting += now.Sub(slot.lastWriteStarted)
+			}
+			s.PerWriter[i] = ws
+		}
+		b.mtx.Unlock()
 	}
 	return s
 }
 
+// maxReady returns how many unread bytes are buffered. In fan-out mode (CopyMulti) that's the
+// most-behind writer, since none of the buffer can be reused until every writer has read past it.
+// b.mtx must be held by the caller.
+func (b *Buffer) maxReady() int {
+	if len(b.writers) == 0 {
+		return int(b.ready.Load())
+	}
+	max := 0
+	for _, s := range b.writers {
+		if !s.done && s.ready > max {
+			max = s.ready
+		}
+	}
+	return max
+}
+
+// publishRead makes n freshly read bytes available to every consumer. b.mtx must be held by the
+// caller.
+func (b *Buffer) publishRead(n int) {
+	if len(b.writers) == 0 {
+		b.ready.Add(int64(n))
+		return
+	}
+	for _, s := range b.writers {
+		if !s.done {
+			s.ready += n
+		}
+	}
+}
+
+// producerBlockingErr reports the error (if any) that should make ReadFrom give up because nobody
+// is left to consume what it reads. b.mtx must be held by the caller.
+func (b *Buffer) producerBlockingErr() error {
+	if len(b.writers) == 0 {
+		return b.writeError
+	}
+	for _, s := range b.writers {
+		if !s.done {
+			return nil
+		}
+	}
+	for _, s := range b.writers {
+		if s.err != nil {
+			return s.err
+		}
+	}
+	return io.ErrClosedPipe
+}
+
 func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
 	var sum int64
 	b.mtx.Lock()
 	defer b.mtx.Unlock()
-	if b.timeStarted.IsZero() {
-		b.timeStarted = time.Now()
+	defer b.markDone()
+	if b.timeStarted.Load() == 0 {
+		b.timeStarted.Store(time.Now().UnixNano())
 	}
 
 	for {
+		ready := b.maxReady()
 		var until int
-		if b.readIndex > b.ready {
+		if b.readIndex > ready {
 			until = len(b.buf)
 		} else {
-			until = b.readIndex - b.ready + len(b.buf)
+			until = b.readIndex - ready + len(b.buf)
 		}
 		buf := b.buf[b.readIndex:until]
-		b.lastReadStarted = time.Now()
 		b.mtx.Unlock()
 
+		var slept time.Duration
+		if b.readLimiter != nil {
+			var allowed int
+			allowed, slept = b.readLimiter.take(len(buf))
+			buf = buf[:allowed]
+		}
+
+		b.lastReadStarted.Store(time.Now().UnixNano())
 		n, err := r.Read(buf)
-		dur := time.Since(b.lastReadStarted)
+		if b.readLimiter != nil && n < len(buf) {
+			b.readLimiter.refund(len(buf) - n)
+		}
+		dur := time.Since(time.Unix(0, b.lastReadStarted.Load()))
 		sum += int64(n)
 
 		b.mtx.Lock()
 		b.readIndex = (b.readIndex + n) % len(b.buf)
-		b.ready += n
-		b.totalBytesRead += int64(n)
-		b.timeSpentReading += dur
-		b.lastReadStarted = time.Time{}
-		b.cond.Signal()
+		b.publishRead(n)
+		b.totalBytesRead.Add(int64(n))
+		b.timeSpentReading.Add(int64(dur))
+		b.timeSpentBlockedOnRateLimit.Add(int64(slept))
+		b.readLatency.observe(dur)
+		b.lastReadStarted.Store(0)
+		b.cond.Broadcast()
 		if err != nil {
 			b.readError = err
 			if err == io.EOF {
@@ -116,13 +410,13 @@ func (b *Buffer) ReadFrom(r io.Reader) (int64, error) {
 			}
 			return sum, err
 		}
-		if b.writeError != nil {
-			return sum, b.writeError
+		if e := b.producerBlockingErr(); e != nil {
+			return sum, e
 		}
-		for b.ready == len(b.buf) {
+		for b.maxReady() == len(b.buf) {
 			b.cond.Wait()
-			if b.writeError != nil {
-				return sum, b.writeError
+			if e := b.producerBlockingErr(); e != nil {
+				return sum, e
 			}
 		}
 	}
@@ -138,16 +432,19 @@ func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
 	var sum int64
 	b.mtx.Lock()
 	defer b.mtx.Unlock()
-	if b.timeStarted.IsZero() {
-		b.timeStarted = time.Now()
+	defer b.markDone()
+	if b.timeStarted.Load() == 0 {
+		b.timeStarted.Store(time.Now().UnixNano())
 	}
 	defer func() {
 		// Stop the clock on the total timer.
-		b.totalTimeSpent += time.Since(b.timeStarted)
-		b.timeStarted = time.Time{}
+		if started := b.timeStarted.Load(); started != 0 {
+			b.totalTimeSpent.Add(int64(time.Since(time.Unix(0, started))))
+		}
+		b.timeStarted.Store(0)
 	}()
 	for {
-		for b.ready == 0 {
+		for b.ready.Load() == 0 {
 			if b.readError != nil {
 				if b.readError == io.EOF {
 					return sum, nil
@@ -156,26 +453,38 @@ func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
 			}
 			b.cond.Wait()
 		}
+		ready := b.ready.Load()
 		var buf []byte
-		if b.readIndex-b.ready >= 0 {
-			buf = b.buf[b.readIndex-b.ready : b.readIndex]
+		if b.readIndex-int(ready) >= 0 {
+			buf = b.buf[b.readIndex-int(ready) : b.readIndex]
 		} else {
-			buf = b.buf[b.readIndex-b.ready+len(b.buf):]
+			buf = b.buf[b.readIndex-int(ready)+len(b.buf):]
 		}
-		b.lastWriteStarted = time.Now()
 		b.mtx.Unlock()
 
 		if len(buf) > maxWrite {
 			buf = buf[:maxWrite]
 		}
+		var slept time.Duration
+		if b.writeLimiter != nil {
+			var allowed int
+			allowed, slept = b.writeLimiter.take(len(buf))
+			buf = buf[:allowed]
+		}
+		b.lastWriteStarted.Store(time.Now().UnixNano())
 		n, err := w.Write(buf)
-		dur := time.Since(b.lastWriteStarted)
+		if b.writeLimiter != nil && n < len(buf) {
+			b.writeLimiter.refund(len(buf) - n)
+		}
+		dur := time.Since(time.Unix(0, b.lastWriteStarted.Load()))
 		sum += int64(n)
 
 		b.mtx.Lock()
-		b.ready -= n
-		b.lastWriteStarted = time.Time{}
-		b.timeSpentWriting += dur
+		b.ready.Add(-int64(n))
+		b.lastWriteStarted.Store(0)
+		b.timeSpentWriting.Add(int64(dur))
+		b.timeSpentBlockedOnRateLimit.Add(int64(slept))
+		b.writeLatency.observe(dur)
 		b.cond.Signal()
 		if err != nil {
 			err = fmt.Errorf("write error: %w", err)
@@ -184,3 +493,203 @@ func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
 		}
 	}
 }
+
+// Buffers are recycled through size-bucketed pools so that repeated short-lived Copy calls don't
+// allocate and garbage collect a fresh multi-megabyte slice every time. Buckets are rounded up to
+// the next power of two so that a given pool only ever serves slices of one capacity.
+var (
+	poolsMtx sync.Mutex
+	pools    = map[int]*sync.Pool{}
+)
+
+// bucketFor rounds size up to the next power of two, with a floor of 1.
+func bucketFor(size int) int {
+	bucket := 1
+	for bucket < size {
+		bucket <<= 1
+	}
+	return bucket
+}
+
+func poolFor(bucket int) *sync.Pool {
+	poolsMtx.Lock()
+	defer poolsMtx.Unlock()
+	p, ok := pools[bucket]
+	if !ok {
+		p = &sync.Pool{}
+		pools[bucket] = p
+	}
+	return p
+}
+
+func getPooledBuffer(size int) []byte {
+	bucket := bucketFor(size)
+	if buf, ok := poolFor(bucket).Get().([]byte); ok {
+		return buf[:size]
+	}
+	return make([]byte, size, bucket)
+}
+
+func putPooledBuffer(buf []byte) {
+	bucket := cap(buf)
+	poolFor(bucket).Put(buf[:bucket])
+}
+
+// rateLimiter is a simple token-bucket limiter used to cap the throughput of one side of a Buffer.
+// The bucket size equals one second worth of bytesPerSec, so bursts of up to a second are allowed.
+type rateLimiter struct {
+	mtx         sync.Mutex
+	bytesPerSec int64
+	tokens      float64
+	last        time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		bytesPerSec: bytesPerSec,
+		tokens:      float64(bytesPerSec),
+		last:        time.Now(),
+	}
+}
+
+func (rl *rateLimiter) setRate(bytesPerSec int64) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	rl.bytesPerSec = bytesPerSec
+}
+
+// take blocks until up to n bytes worth of tokens (capped to one second of traffic, so a single
+// call never withholds more than the bucket's burst size) are available, reserves them, and
+// returns how many bytes it reserved and how long it slept waiting for them. The reserved amount
+// can be less than n, in which case the caller must shrink the buffer it hands to Read/Write to
+// that many bytes so a single large call can't bypass the limit; if the call ends up transferring
+// fewer bytes than were reserved, give the rest back via refund.
+func (rl *rateLimiter) take(n int) (int, time.Duration) {
+	rl.mtx.Lock()
+	if rl.bytesPerSec <= 0 {
+		rl.mtx.Unlock()
+		return n, 0
+	}
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * float64(rl.bytesPerSec)
+	if max := float64(rl.bytesPerSec); rl.tokens > max {
+		rl.tokens = max
+	}
+	rl.last = now
+	need := float64(n)
+	if max := float64(rl.bytesPerSec); need > max {
+		need = max
+	}
+	if rl.tokens >= need {
+		rl.tokens -= need
+		rl.mtx.Unlock()
+		return int(need), 0
+	}
+	wait := time.Duration((need - rl.tokens) / float64(rl.bytesPerSec) * float64(time.Second))
+	rl.tokens = 0
+	rl.last = now.Add(wait)
+	rl.mtx.Unlock()
+	time.Sleep(wait)
+	return int(need), wait
+}
+
+// refund returns n bytes worth of tokens that were reserved via take but never transferred, e.g.
+// because the underlying Read/Write returned fewer bytes than it was offered.
+func (rl *rateLimiter) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	if rl.bytesPerSec <= 0 {
+		return
+	}
+	rl.tokens += float64(n)
+	if max := float64(rl.bytesPerSec); rl.tokens > max {
+		rl.tokens = max
+	}
+}
+
+// latencyHistogram is a lightweight exponentially-bucketed counter array used to estimate
+// percentiles of individual Read/Write call durations without storing every sample. It covers
+// the range [histogramMin, histogramMax]; samples outside that range are clamped into the first
+// or last bucket. It has its own mutex, independent of Buffer's, so Stats can take a snapshot
+// without contending with b.mtx.
+type latencyHistogram struct {
+	mtx    sync.Mutex
+	counts [histogramBuckets]uint64
+	count  uint64
+	max    time.Duration
+}
+
+const (
+	histogramMin     = time.Microsecond
+	histogramMax     = 10 * time.Second
+	histogramBuckets = 128
+)
+
+// histogramScale is the per-bucket exponential growth factor, precomputed so bucketIndex and
+// bucketUpperBound only need a single log/exp call.
+var histogramScale = math.Log(float64(histogramMax)/float64(histogramMin)) / float64(histogramBuckets)
+
+func (h *latencyHistogram) observe(dur time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if dur > h.max {
+		h.max = dur
+	}
+	h.counts[bucketIndex(dur)]++
+	h.count++
+}
+
+func bucketIndex(dur time.Duration) int {
+	if dur <= histogramMin {
+		return 0
+	}
+	if dur >= histogramMax {
+		return histogramBuckets - 1
+	}
+	idx := int(math.Log(float64(dur)/float64(histogramMin)) / histogramScale)
+	if idx < 0 {
+		idx = 0
+	} else if idx >= histogramBuckets {
+		idx = histogramBuckets - 1
+	}
+	return idx
+}
+
+// bucketUpperBound returns the upper edge of bucket idx, used as the percentile estimate for any
+// sample that fell into it.
+func bucketUpperBound(idx int) time.Duration {
+	return time.Duration(float64(histogramMin) * math.Exp(float64(idx+1)*histogramScale))
+}
+
+// percentile assumes h.mtx is already held; use snapshot for a locked, public-facing read.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(p * float64(h.count)))
+	if target < 1 {
+		target = 1
+	}
+	var cum uint64
+	for i, c := range h.counts {
+		cum += c
+		if cum >= target {
+			return bucketUpperBound(i)
+		}
+	}
+	return h.max
+}
+
+func (h *latencyHistogram) snapshot() Latency {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return Latency{
+		P50: h.percentile(0.50),
+		P90: h.percentile(0.90),
+		P98: h.percentile(0.98),
+		Max: h.max,
+	}
+}
@@ -0,0 +1,190 @@
+package measuringringbuffer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimitAccounting checks that time spent blocked inside the rate limiter is attributed to
+// TimeSpentBlockedOnRateLimit, not TimeSpentReading/TimeSpentWriting, even when Stats is polled
+// concurrently with an in-flight rate-limited Read/Write.
+func TestRateLimitAccounting(t *testing.T) {
+	const rate = 1024 // bytes/sec, deliberately slow relative to the data size below
+	b := New(4096, WithReadRateLimit(rate), WithWriteRateLimit(rate))
+	r := strings.NewReader(strings.Repeat("x", 4096))
+	var w bytes.Buffer
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Copy(&w, r)
+	}()
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if s := b.Stats(); s.TimeSpentReading > s.TotalTime || s.TimeSpentWriting > s.TotalTime {
+			t.Fatalf("TimeSpentReading/Writing must never exceed TotalTime, got %+v", s)
+		}
+	}
+	<-done
+	b.Close()
+
+	if s := b.Stats(); s.TimeSpentBlockedOnRateLimit == 0 {
+		t.Fatalf("expected non-zero TimeSpentBlockedOnRateLimit with a %d B/s limit, got %+v", rate, s)
+	}
+	if s := b.Stats(); s.TimeSpentReading >= s.TimeSpentBlockedOnRateLimit || s.TimeSpentWriting >= s.TimeSpentBlockedOnRateLimit {
+		t.Fatalf("rate limiter sleep must not be counted as TimeSpentReading/Writing, got %+v", s)
+	}
+}
+
+// TestRateLimitCapsOversizedReads checks that a single Read offered a slice bigger than the
+// limiter's one-second burst still gets capped down, instead of being allowed through unthrottled
+// because the limiter only clamped its internal wait calculation and not the buffer actually handed
+// to Read.
+func TestRateLimitCapsOversizedReads(t *testing.T) {
+	const rate = 2048 // bytes/sec
+	const size = 3 * rate
+	b := New(size, WithReadRateLimit(rate))
+	r := strings.NewReader(strings.Repeat("x", size)) // fills the whole buffer in a single Read
+	var w bytes.Buffer
+
+	start := time.Now()
+	b.Copy(&w, r)
+	b.Close()
+	el := time.Since(start)
+	// 3x the per-second burst should take on the order of 2 extra seconds beyond the first free burst.
+	if el < 1500*time.Millisecond {
+		t.Fatalf("a %d-byte transfer at %d B/s completed in %v, rate limit had no effect", size, rate, el)
+	}
+}
+
+// TestRateLimitRefundsUnusedReservation checks that a Read/Write which doesn't fill the slice it
+// was offered only gets charged for the bytes it actually transferred, not for the whole slice, so
+// a reader/writer that trickles bytes isn't drained far faster than its real throughput.
+func TestRateLimitRefundsUnusedReservation(t *testing.T) {
+	const total = 2000
+	// The limit is far higher than the trickle reader can ever sustain; if take() still charged
+	// the full offered buffer instead of the single byte returned, the bucket would be driven
+	// into large waits despite the generous limit.
+	b := New(1<<16, WithReadRateLimit(1_000_000), WithWriteRateLimit(1_000_000))
+	r := &trickleReader{remaining: total}
+	var w bytes.Buffer
+
+	start := time.Now()
+	n, err := b.Copy(&w, r)
+	b.Close()
+	el := time.Since(start)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if n != total {
+		t.Fatalf("got %d bytes, want %d", n, total)
+	}
+	if el > time.Second {
+		t.Fatalf("trickling %d bytes took %v, the limiter must be over-charging for unused reservation", total, el)
+	}
+}
+
+// trickleReader returns a single byte per Read call, to exercise the case where a call doesn't
+// fill the slice it was offered.
+type trickleReader struct {
+	remaining int
+}
+
+func (tr *trickleReader) Read(p []byte) (int, error) {
+	if tr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	tr.remaining--
+	p[0] = 'y'
+	return 1, nil
+}
+
+// TestCopyMultiFanOut exercises CopyMulti's per-writer backpressure: with a buffer much smaller
+// than the data, the producer has to block on the slow writer while the fast one keeps draining,
+// and both should still end up with an identical, complete copy of the input.
+func TestCopyMultiFanOut(t *testing.T) {
+	data := strings.Repeat("the quick brown fox jumps over the lazy dog ", 200)
+	b := New(64)
+	var fast bytes.Buffer
+	var slow bytes.Buffer
+	slowWriter := &delayedWriter{w: &slow, delay: time.Millisecond}
+
+	n, err := b.CopyMulti(strings.NewReader(data), &fast, slowWriter)
+	b.Close()
+	if err != nil {
+		t.Fatalf("CopyMulti: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("CopyMulti returned %d bytes, want %d", n, len(data))
+	}
+	if fast.String() != data {
+		t.Fatalf("fast writer got %d bytes, want %d", fast.Len(), len(data))
+	}
+	if slow.String() != data {
+		t.Fatalf("slow writer got %d bytes, want %d", slow.Len(), len(data))
+	}
+}
+
+// delayedWriter wraps an io.Writer with a fixed delay before every Write call, to simulate a slow
+// consumer.
+type delayedWriter struct {
+	w     io.Writer
+	delay time.Duration
+}
+
+func (d *delayedWriter) Write(p []byte) (int, error) {
+	time.Sleep(d.delay)
+	return d.w.Write(p)
+}
+
+// TestStatsRacesWithClose polls Stats concurrently with a Copy/Close pair, the exact pattern
+// cmd/fv's background ticker and final Close use, to catch Stats reading any field that Close
+// mutates outside of the atomics it's meant to rely on.
+func TestStatsRacesWithClose(t *testing.T) {
+	b := New(4096)
+	r := strings.NewReader(strings.Repeat("z", 1<<20))
+	var w bytes.Buffer
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				b.Stats()
+			}
+		}
+	}()
+
+	if _, err := b.Copy(&w, r); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	b.Close()
+	close(stop)
+}
+
+// BenchmarkStatsPolling measures the cost of Stats on a Buffer that's actively being read from and
+// written to, i.e. the contention Stats was made lock-free to avoid.
+func BenchmarkStatsPolling(b *testing.B) {
+	buf := New(1 << 20)
+	go buf.ReadFrom(&infiniteReader{})
+	go buf.WriteTo(io.Discard)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Stats()
+	}
+}
+
+// infiniteReader never ends: every Read reports the whole slice as read without writing to it. It
+// keeps a Buffer busy for the duration of a benchmark.
+type infiniteReader struct{}
+
+func (infiniteReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
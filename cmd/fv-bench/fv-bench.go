@@ -0,0 +1,135 @@
+// Binary fv-bench drives measuringringbuffer.Buffer with synthetic readers and writers so you can
+// see how buffer size and peer slowness affect throughput before picking a size for fv.
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/Jille/measuringringbuffer"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/pflag"
+)
+
+var (
+	totalSize   = pflag.Int64P("total-size", "n", 64, "Megabytes of synthetic data to copy per run")
+	bufferSize  = pflag.IntP("buffer-size", "s", 8, "Megabytes of buffer (ignored in --sweep mode)")
+	readerDelay = pflag.Duration("reader-delay", 0, "Delay before every synthetic Read call, to simulate a slow producer")
+	writerDelay = pflag.Duration("writer-delay", 0, "Delay before every synthetic Write call, to simulate a slow consumer")
+	runs        = pflag.IntP("runs", "r", 5, "Number of copies to run per buffer size, used to compute percentiles")
+	sweep       = pflag.Bool("sweep", false, "Run the same workload across a range of buffer sizes and print a table")
+)
+
+// sweepSizesMB are the buffer sizes tried in --sweep mode.
+var sweepSizesMB = []int{1, 2, 4, 8, 16, 32, 64, 128}
+
+func main() {
+	pflag.Parse()
+
+	if *sweep {
+		fmt.Printf("%-12s %-10s %-10s %-10s %-10s %-7s %-7s\n", "Buffer", "p50", "p90", "p98", "Throughput", "Full%", "Empty%")
+		for _, mb := range sweepSizesMB {
+			printRow(humanize.Bytes(uint64(mb)*1024*1024), runBenchmark(mb*1024*1024))
+		}
+		return
+	}
+
+	printRow(humanize.Bytes(uint64(*bufferSize)*1024*1024), runBenchmark(*bufferSize*1024*1024))
+}
+
+// result holds one buffer size's aggregated measurements across *runs copies.
+type result struct {
+	copyTimes   []time.Duration
+	bytesPerSec float64
+	fullPct     float64 // approx. fraction of time the producer was blocked because the buffer was full
+	emptyPct    float64 // approx. fraction of time the consumer was blocked because the buffer was empty
+}
+
+func runBenchmark(bufSize int) result {
+	times := make([]time.Duration, *runs)
+	var totalFullPct, totalEmptyPct float64
+	for i := 0; i < *runs; i++ {
+		buf := measuringringbuffer.New(bufSize)
+		r := &delayedReader{r: io.LimitReader(zeroReader{}, *totalSize*1024*1024), delay: *readerDelay}
+		w := &delayedWriter{w: io.Discard, delay: *writerDelay}
+
+		start := time.Now()
+		buf.Copy(w, r)
+		times[i] = time.Since(start)
+
+		s := buf.Stats()
+		buf.Close()
+		if s.TotalTime > 0 {
+			// Time not spent inside Read/Write is time spent blocked waiting for the other side,
+			// i.e. the buffer being full (producer) or empty (consumer).
+			totalFullPct += 100 * (1 - float64(s.TimeSpentReading)/float64(s.TotalTime))
+			totalEmptyPct += 100 * (1 - float64(s.TimeSpentWriting)/float64(s.TotalTime))
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return result{
+		copyTimes:   times,
+		bytesPerSec: float64(*totalSize*1024*1024) / percentile(times, 0.50).Seconds(),
+		fullPct:     totalFullPct / float64(*runs),
+		emptyPct:    totalEmptyPct / float64(*runs),
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(p * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+func printRow(label string, r result) {
+	fmt.Printf("%-12s %-10s %-10s %-10s %-10s %-7.0f %-7.0f\n",
+		label,
+		percentile(r.copyTimes, 0.50).Round(time.Millisecond),
+		percentile(r.copyTimes, 0.90).Round(time.Millisecond),
+		percentile(r.copyTimes, 0.98).Round(time.Millisecond),
+		humanize.SIWithDigits(r.bytesPerSec, 2, "B/s"),
+		r.fullPct,
+		r.emptyPct,
+	)
+}
+
+// zeroReader is an infinite source of zero bytes, used so the benchmark doesn't spend its time
+// generating random data instead of exercising the buffer.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// delayedReader simulates a producer that takes delay to hand over each chunk.
+type delayedReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (d *delayedReader) Read(p []byte) (int, error) {
+	if d.delay > 0 {
+		time.Sleep(d.delay)
+	}
+	return d.r.Read(p)
+}
+
+// delayedWriter simulates a consumer that takes delay to accept each chunk.
+type delayedWriter struct {
+	w     io.Writer
+	delay time.Duration
+}
+
+func (d *delayedWriter) Write(p []byte) (int, error) {
+	if d.delay > 0 {
+		time.Sleep(d.delay)
+	}
+	return d.w.Write(p)
+}
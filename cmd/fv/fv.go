@@ -16,18 +16,31 @@ import (
 )
 
 var (
-	size = pflag.IntP("size", "s", 8, "Number of megabytes of buffer")
+	size      = pflag.IntP("size", "s", 8, "Number of megabytes of buffer")
+	rateLimit = pflag.Int64P("rate-limit", "L", 0, "Limit transfer rate to this many bytes per second (0 = unlimited)")
+	latency   = pflag.Bool("latency", false, "Print Read/Write latency percentiles")
 
 	printMtx    sync.Mutex
-	widths      [6]int
-	parts       [6]string
+	widths      []int
+	parts       []string
 	printBuffer bytes.Buffer
 )
 
 func main() {
 	pflag.Parse()
 
-	buf := measuringringbuffer.New(*size * 1024 * 1024)
+	numParts := 6
+	if *latency {
+		numParts = 8
+	}
+	widths = make([]int, numParts)
+	parts = make([]string, numParts)
+
+	var opts []measuringringbuffer.Option
+	if *rateLimit > 0 {
+		opts = append(opts, measuringringbuffer.WithReadRateLimit(*rateLimit), measuringringbuffer.WithWriteRateLimit(*rateLimit))
+	}
+	buf := measuringringbuffer.New(*size*1024*1024, opts...)
 	go func() {
 		for range time.Tick(time.Second / 2) {
 			printMtx.Lock()
@@ -41,6 +54,7 @@ func main() {
 	_, err := buf.Copy(os.Stdout, os.Stdin)
 	printMtx.Lock()
 	printStats(buf.Stats(), '\n')
+	buf.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -53,6 +67,10 @@ func printStats(s measuringringbuffer.Stats, lineEnd byte) error {
 	parts[3] = fmt.Sprintf("S: %s", humanize.SIWithDigits(float64(s.BytesRead)/s.TotalTime.Seconds(), 2, "B/s"))
 	parts[4] = fmt.Sprintf("R: % 2d%%", int(100*s.TimeSpentReading/s.TotalTime))
 	parts[5] = fmt.Sprintf("W: % 2d%%", int(100*s.TimeSpentWriting/s.TotalTime))
+	if len(parts) > 6 {
+		parts[6] = fmt.Sprintf("RL p50/p98/max: %s/%s/%s", s.ReadLatency.P50, s.ReadLatency.P98, s.ReadLatency.Max)
+		parts[7] = fmt.Sprintf("WL p50/p98/max: %s/%s/%s", s.WriteLatency.P50, s.WriteLatency.P98, s.WriteLatency.Max)
+	}
 	for i, p := range parts {
 		if len(p) > widths[i] {
 			widths[i] = len(p)